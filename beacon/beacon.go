@@ -0,0 +1,140 @@
+// Package beacon implements simple LAN discovery over UDP broadcast: a
+// Beacon periodically broadcasts a fixed payload and reports every
+// matching beacon it sees from other nodes on the segment.
+package beacon
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Signal is a single beacon received from the network.
+type Signal struct {
+	Addr     string // sender address, "ip:port"
+	Transmit []byte // raw beacon payload
+}
+
+const interval = 1 * time.Second
+
+// Beacon broadcasts a payload at a steady interval and delivers beacons
+// seen on the local segment on its Signals channel.
+type Beacon struct {
+	port    int
+	conn    *net.UDPConn
+	addr    string
+	signals chan *Signal
+	quit    chan struct{}
+
+	noEcho bool
+	filter []byte
+	ticker *time.Ticker
+
+	mu      sync.Mutex // guards payload, set by Publish and read by broadcast on its own goroutine, and the one-time start of broadcast
+	payload []byte
+}
+
+// New creates a Beacon listening for and broadcasting on port.
+func New(port int) (*Beacon, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Beacon{
+		port:    port,
+		conn:    conn,
+		addr:    localIP(),
+		signals: make(chan *Signal),
+		quit:    make(chan struct{}),
+	}
+	go b.listen()
+	return b, nil
+}
+
+// Addr returns the local interface address beacons are sent from.
+func (b *Beacon) Addr() string { return b.addr }
+
+// NoEcho suppresses delivery of our own beacon back to Signals().
+func (b *Beacon) NoEcho() { b.noEcho = true }
+
+// Subscribe restricts delivered beacons to those whose payload starts
+// with filter.
+func (b *Beacon) Subscribe(filter []byte) { b.filter = filter }
+
+// Publish starts (or replaces) the payload broadcast once a second. Safe
+// to call from any goroutine, including after broadcast has started.
+func (b *Beacon) Publish(payload []byte) {
+	b.mu.Lock()
+	b.payload = payload
+	if b.ticker == nil {
+		b.ticker = time.NewTicker(interval)
+		go b.broadcast()
+	}
+	b.mu.Unlock()
+}
+
+// Signals returns the channel on which received beacons are delivered.
+func (b *Beacon) Signals() chan *Signal { return b.signals }
+
+// Close stops broadcasting and listening.
+func (b *Beacon) Close() error {
+	close(b.quit)
+	return b.conn.Close()
+}
+
+func (b *Beacon) broadcast() {
+	baddr := &net.UDPAddr{IP: net.IPv4bcast, Port: b.port}
+	for {
+		select {
+		case <-b.quit:
+			return
+		case <-b.ticker.C:
+			b.mu.Lock()
+			payload := b.payload
+			b.mu.Unlock()
+			if payload != nil {
+				b.conn.WriteTo(payload, baddr)
+			}
+		}
+	}
+}
+
+func (b *Beacon) listen() {
+	buf := make([]byte, 2048)
+	for {
+		n, raddr, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-b.quit:
+				return
+			default:
+				continue
+			}
+		}
+		if b.filter != nil && (n < len(b.filter) || string(buf[:len(b.filter)]) != string(b.filter)) {
+			continue
+		}
+		if b.noEcho && raddr.IP.String() == b.addr {
+			continue
+		}
+
+		data := append([]byte(nil), buf[:n]...)
+		select {
+		case b.signals <- &Signal{Addr: raddr.String(), Transmit: data}:
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+// localIP returns the address of the interface used to reach the
+// network, which is what we advertise as our own beacon source.
+func localIP() string {
+	conn, err := net.Dial("udp4", "8.8.8.8:80")
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
@@ -0,0 +1,74 @@
+package gyre
+
+import "github.com/armen/gyre/nat"
+
+// Config controls how a Node is constructed. The zero value gives the same
+// behaviour as plain NewNode(): a random dynamic port, LAN-only discovery
+// through the beacon, no NAT traversal, and an unauthenticated, unencrypted
+// connection to every peer that shows up on the segment.
+type Config struct {
+	// NAT, when set, is used to map the node's inbox port on the local
+	// gateway so peers outside the LAN can reach it. See the nat
+	// package for the available mechanisms (nat.UPnP, nat.PMP,
+	// nat.ExtIP, nat.Any).
+	NAT nat.Interface
+
+	// NodeKey, when set, gives the node a stable, cryptographic
+	// identity: Uuid/Identity are derived from the key instead of
+	// chosen at random, the public key is advertised in the beacon and
+	// HELLO, and the HELLO handshake is used to derive per-peer
+	// ChaCha20-Poly1305 keys that encrypt all further Transit traffic.
+	// Use GenerateNodeKey or LoadNodeKey to obtain one.
+	NodeKey *NodeKey
+
+	// PeerAuthorizer, when set, is consulted with a peer's static
+	// public key once its HELLO signature has been verified; returning
+	// false rejects the peer (it never becomes ready and an
+	// EventReject is emitted) instead of accepting any authenticated
+	// key. Only meaningful alongside NodeKey.
+	PeerAuthorizer func(pubkey []byte) bool
+
+	// Protocols registers subprotocols up front, equivalent to calling
+	// node.RegisterProtocol for each one right after construction. See
+	// Protocol and Node.RegisterProtocol.
+	Protocols []Protocol
+
+	// Store, when set, persists known peers across restarts and is
+	// consulted on start-up to reconnect directly to them instead of
+	// waiting to rediscover them over the beacon. NewFileStore gives a
+	// simple file-backed default.
+	Store PeerStore
+
+	// MaxPeers, when positive, caps how many peers this node will
+	// accept at once: once reached, a new requirePeer call (inbound
+	// HELLO, beacon sighting, or bootstrap dial) is sent a
+	// Goodbye{ReasonTooManyPeers} instead of being accepted. Zero
+	// means unlimited, matching plain NewNode() behaviour.
+	MaxPeers int
+
+	// Bootstrap lists well-known seed peers to dial directly on
+	// start-up, for clusters that span more than one LAN segment where
+	// the beacon can't reach. Each entry has the form
+	// "identity@host:port", where identity is the seed's hex node
+	// UUID (Node.Identity). Bootstrap peers are marked sticky: pingPeer
+	// retries them with backoff instead of forgetting them the first
+	// time they're unreachable.
+	Bootstrap []string
+}
+
+// Option customizes a Config in functional-option style, for callers who'd
+// rather compose a handful of settings than build a whole Config literal.
+// Pass any number of them to NewNodeWithConfig after its Config argument
+// (Config{} when the options are all you need); each is applied in order
+// and simply overwrites the field it controls.
+type Option func(*Config)
+
+// WithNodeKey sets Config.NodeKey.
+func WithNodeKey(key *NodeKey) Option {
+	return func(c *Config) { c.NodeKey = key }
+}
+
+// WithPeerAuthorizer sets Config.PeerAuthorizer.
+func WithPeerAuthorizer(authorize func(pubkey []byte) bool) Option {
+	return func(c *Config) { c.PeerAuthorizer = authorize }
+}
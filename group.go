@@ -0,0 +1,33 @@
+package gyre
+
+import "github.com/armen/gyre/msg"
+
+// group tracks which known peers have announced membership in a named
+// group.
+type group struct {
+	name  string
+	peers map[string]*peer
+}
+
+func newGroup(name string) *group {
+	return &group{
+		name:  name,
+		peers: make(map[string]*peer),
+	}
+}
+
+func (g *group) join(peer *peer) {
+	g.peers[peer.identity] = peer
+}
+
+func (g *group) leave(peer *peer) {
+	delete(g.peers, peer.identity)
+}
+
+// send delivers m to every peer in the group. Each peer gets its own
+// clone since peer.send stamps it with a peer-specific sequence number.
+func (g *group) send(m msg.Transit) {
+	for _, peer := range g.peers {
+		peer.send(msg.Clone(m))
+	}
+}
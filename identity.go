@@ -0,0 +1,130 @@
+package gyre
+
+import (
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// NodeKey is a node's long-lived identity keypair. A Node constructed
+// with a NodeKey derives its Uuid/Identity from the public key instead
+// of choosing one at random, so it keeps the same identity across
+// restarts, and can sign its ephemeral handshake key so peers can
+// authenticate it.
+type NodeKey struct {
+	Public  ed25519.PublicKey
+	Private ed25519.PrivateKey
+}
+
+// GenerateNodeKey creates a fresh node key.
+func GenerateNodeKey() (*NodeKey, error) {
+	pub, priv, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeKey{Public: pub, Private: priv}, nil
+}
+
+// LoadNodeKey reads a node key previously written by Save from path, or
+// generates and saves a new one if the file doesn't exist yet. This is
+// how a long-running deployment gives its node a stable identity across
+// restarts.
+func LoadNodeKey(path string) (*NodeKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, genErr := GenerateNodeKey()
+		if genErr != nil {
+			return nil, genErr
+		}
+		return key, key.Save(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != ed25519.PrivateKeySize {
+		return nil, errors.New("gyre: malformed node key file")
+	}
+	priv := ed25519.PrivateKey(data)
+	return &NodeKey{Public: priv.Public().(ed25519.PublicKey), Private: priv}, nil
+}
+
+// Save writes the private key to path so a later LoadNodeKey call
+// restores the same identity. The file is created with owner-only
+// permissions since it holds secret key material.
+func (k *NodeKey) Save(path string) error {
+	return ioutil.WriteFile(path, k.Private, 0600)
+}
+
+// uuid derives this node's 16-byte UUID deterministically from its
+// public key, so the same keypair always yields the same identity.
+func (k *NodeKey) uuid() []byte {
+	sum := sha256.Sum256(k.Public)
+	return sum[:16]
+}
+
+// generateEphemeral creates a fresh X25519 keypair used for a single
+// peer connection's handshake.
+func generateEphemeral() (priv, pub []byte, err error) {
+	priv = make([]byte, curve25519.ScalarSize)
+	if _, err = io.ReadFull(crand.Reader, priv); err != nil {
+		return nil, nil, err
+	}
+	pub, err = curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, pub, nil
+}
+
+// handshakeTranscript is what a HELLO's Signature actually covers: the
+// sender's ephemeral key bound to the identity of the peer it's
+// addressed to. Without the recipient identity folded in, a Signature
+// captured off one connection could be replayed verbatim into a HELLO
+// aimed at a different peer; binding it here means a replayed Signature
+// only verifies for the connection it was originally produced for.
+func handshakeTranscript(ephemeral []byte, recipientIdentity string) []byte {
+	return append(append([]byte{}, ephemeral...), []byte(recipientIdentity)...)
+}
+
+// completeHandshake derives this peer's per-direction ChaCha20-Poly1305
+// keys from our ephemeral private key and the peer's ephemeral public
+// key, now that both Hellos have crossed the wire. myIdentity/peerIdentity
+// break the symmetry so both sides agree on which derived key is used in
+// which direction.
+func (p *peer) completeHandshake(remoteEphemeral []byte, myIdentity, peerIdentity string) error {
+	shared, err := curve25519.X25519(p.ephemeralPriv, remoteEphemeral)
+	if err != nil {
+		return err
+	}
+
+	sendLabel, recvLabel := "gyre-a2b", "gyre-b2a"
+	if myIdentity > peerIdentity {
+		sendLabel, recvLabel = recvLabel, sendLabel
+	}
+
+	sendAEAD, err := chacha20poly1305.New(deriveKey(shared, sendLabel))
+	if err != nil {
+		return err
+	}
+	recvAEAD, err := chacha20poly1305.New(deriveKey(shared, recvLabel))
+	if err != nil {
+		return err
+	}
+
+	p.sendAEAD, p.recvAEAD = sendAEAD, recvAEAD
+	return nil
+}
+
+func deriveKey(shared []byte, label string) []byte {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write([]byte(label))
+	return h.Sum(nil)
+}
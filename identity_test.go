@@ -0,0 +1,127 @@
+package gyre
+
+import (
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"testing"
+
+	"github.com/armen/gyre/msg"
+)
+
+// newTestPeer returns a peer with the ephemeral keypair requirePeer would
+// have generated for it when we first heard of it, which authenticate
+// requires to complete the handshake.
+func newTestPeer(identity string) *peer {
+	p := newPeer(identity)
+	priv, _, err := generateEphemeral()
+	if err != nil {
+		panic(err)
+	}
+	p.ephemeralPriv = priv
+	return p
+}
+
+// signedHello builds a Hello whose Signature covers ephemeral bound to
+// recipient, signed by signKey, claiming staticKey as the sender's
+// long-lived identity.
+func signedHello(ephemeral []byte, staticKey ed25519.PublicKey, signKey ed25519.PrivateKey, recipient string) *msg.Hello {
+	m := msg.NewHello()
+	m.Ephemeral = ephemeral
+	m.StaticKey = []byte(staticKey)
+	m.Signature = ed25519.Sign(signKey, handshakeTranscript(ephemeral, recipient))
+	return m
+}
+
+func TestAuthenticateWithoutNodeKeyAlwaysSucceeds(t *testing.T) {
+	n := &Node{Identity: "SERVER"}
+	if !n.authenticate(newTestPeer("PEER"), &msg.Hello{}) {
+		t.Fatal("authenticate with no NodeKey configured must always succeed")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	serverKey, err := GenerateNodeKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientPriv, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, otherPriv, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		hello      func(recipient string) *msg.Hello
+		authorizer func(pubkey []byte) bool
+		want       bool
+	}{
+		{
+			name: "no ephemeral is rejected, not given a free pass",
+			hello: func(recipient string) *msg.Hello {
+				return &msg.Hello{}
+			},
+			want: false,
+		},
+		{
+			name: "valid signature bound to our identity succeeds",
+			hello: func(recipient string) *msg.Hello {
+				_, ephPub, _ := generateEphemeral()
+				return signedHello(ephPub, clientPub, clientPriv, recipient)
+			},
+			want: true,
+		},
+		{
+			name: "signature bound to a different recipient is rejected",
+			hello: func(recipient string) *msg.Hello {
+				_, ephPub, _ := generateEphemeral()
+				return signedHello(ephPub, clientPub, clientPriv, "SOMEONE-ELSE")
+			},
+			want: false,
+		},
+		{
+			name: "signature from a key other than the claimed StaticKey is rejected",
+			hello: func(recipient string) *msg.Hello {
+				_, ephPub, _ := generateEphemeral()
+				m := signedHello(ephPub, otherPub, otherPriv, recipient)
+				m.StaticKey = []byte(clientPub) // claims clientPub but signed with otherPriv
+				return m
+			},
+			want: false,
+		},
+		{
+			name: "authorizer rejecting the static key is honoured",
+			hello: func(recipient string) *msg.Hello {
+				_, ephPub, _ := generateEphemeral()
+				return signedHello(ephPub, clientPub, clientPriv, recipient)
+			},
+			authorizer: func(pubkey []byte) bool { return false },
+			want:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Node{Identity: "SERVER", key: serverKey, authorizer: tt.authorizer}
+			peer := newTestPeer("PEER")
+			if got := n.authenticate(peer, tt.hello(n.Identity)); got != tt.want {
+				t.Fatalf("authenticate() = %v, want %v", got, tt.want)
+			}
+			if tt.want && peer.sendAEAD == nil {
+				t.Fatal("a successful authenticate must leave the peer with a completed handshake")
+			}
+		})
+	}
+}
+
+func TestHandshakeTranscriptBindsRecipient(t *testing.T) {
+	ephemeral := []byte("an-ephemeral-public-key")
+	a := handshakeTranscript(ephemeral, "PEER-A")
+	b := handshakeTranscript(ephemeral, "PEER-B")
+	if string(a) == string(b) {
+		t.Fatal("handshakeTranscript must differ for different recipients, or a Signature could be replayed onto another connection")
+	}
+}
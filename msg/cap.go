@@ -0,0 +1,8 @@
+package msg
+
+// Cap identifies one side of a capability negotiation: a named
+// subprotocol at a given version, as advertised in a peer's HELLO.
+type Cap struct {
+	Name    string
+	Version uint
+}
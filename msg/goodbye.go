@@ -0,0 +1,34 @@
+package msg
+
+// Disconnect reasons carried by Goodbye, letting the receiving side tell
+// a deliberate hang-up apart from a network partition.
+const (
+	ReasonShutdown byte = iota + 1
+	ReasonTimeout
+	ReasonProtocolError
+	ReasonDuplicateIdentity
+	ReasonUnauthorized
+	ReasonTooManyPeers
+	ReasonIncompatibleVersion
+)
+
+// Goodbye is sent just before closing a connection on purpose, so the
+// peer on the other end doesn't have to wait out its own reaper to learn
+// why we're gone.
+type Goodbye struct {
+	envelope
+	Seq    uint16
+	Reason byte
+	Text   string
+}
+
+// NewGoodbye creates a Goodbye with the given reason and an optional
+// human-readable explanation.
+func NewGoodbye(reason byte, text string) *Goodbye {
+	return &Goodbye{Reason: reason, Text: text}
+}
+
+func (g *Goodbye) String() string             { return "GOODBYE" }
+func (g *Goodbye) Sequence() uint16           { return g.Seq }
+func (g *Goodbye) SetSequence(seq uint16)     { g.Seq = seq }
+func (g *Goodbye) Marshal() ([][]byte, error) { return encode(idGoodbye, g) }
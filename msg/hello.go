@@ -0,0 +1,43 @@
+package msg
+
+// Hello is sent as the first message between two peers. It announces the
+// sender's inbox address, current headers and group memberships, and
+// (when the node has a long-lived key) the data needed to authenticate
+// the peer and establish an encrypted channel.
+type Hello struct {
+	envelope
+	Seq       uint16
+	Ipaddress string
+	Mailbox   uint16
+	Groups    []string
+	Status    byte
+	Headers   map[string]string
+
+	// Capabilities lists every subprotocol (including the implicit
+	// built-in "gyre" protocol) this node supports, so the receiving
+	// side can work out which ones are shared. See BaseProtocolLength
+	// and ProtoMsg.
+	Capabilities []Cap
+
+	// Ephemeral, StaticKey and Signature are only set when the sending
+	// node has a NodeKey configured. Ephemeral is an X25519 public key
+	// generated fresh for this peer connection; StaticKey is the
+	// sender's long-lived Ed25519 public key; Signature is StaticKey's
+	// signature over Ephemeral bound to the recipient's identity,
+	// proving the ephemeral key really comes from the holder of
+	// StaticKey and really is meant for this connection, not replayed
+	// from a Hello captured on another one.
+	Ephemeral []byte
+	StaticKey []byte
+	Signature []byte
+}
+
+// NewHello creates a new, empty Hello message.
+func NewHello() *Hello {
+	return &Hello{Headers: make(map[string]string)}
+}
+
+func (h *Hello) String() string             { return "HELLO" }
+func (h *Hello) Sequence() uint16           { return h.Seq }
+func (h *Hello) SetSequence(seq uint16)     { h.Seq = seq }
+func (h *Hello) Marshal() ([][]byte, error) { return encode(idHello, h) }
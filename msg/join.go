@@ -0,0 +1,20 @@
+package msg
+
+// Join announces that the sender has joined a group, at a given status
+// (change counter) value.
+type Join struct {
+	envelope
+	Seq    uint16
+	Group  string
+	Status byte
+}
+
+// NewJoin creates a new, empty Join message.
+func NewJoin() *Join {
+	return &Join{}
+}
+
+func (j *Join) String() string             { return "JOIN" }
+func (j *Join) Sequence() uint16           { return j.Seq }
+func (j *Join) SetSequence(seq uint16)     { j.Seq = seq }
+func (j *Join) Marshal() ([][]byte, error) { return encode(idJoin, j) }
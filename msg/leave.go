@@ -0,0 +1,20 @@
+package msg
+
+// Leave announces that the sender has left a group, at a given status
+// (change counter) value.
+type Leave struct {
+	envelope
+	Seq    uint16
+	Group  string
+	Status byte
+}
+
+// NewLeave creates a new, empty Leave message.
+func NewLeave() *Leave {
+	return &Leave{}
+}
+
+func (l *Leave) String() string             { return "LEAVE" }
+func (l *Leave) Sequence() uint16           { return l.Seq }
+func (l *Leave) SetSequence(seq uint16)     { l.Seq = seq }
+func (l *Leave) Marshal() ([][]byte, error) { return encode(idLeave, l) }
@@ -0,0 +1,182 @@
+// Package msg implements the wire messages exchanged between Gyre peers
+// over the ROUTER/DEALER inbox sockets.
+//
+// The body of every message (everything past the single-byte id frame)
+// is a Go gob stream, not a hand-rolled binary layout: gob already
+// refuses to read a field's declared length past the bytes actually
+// available, so truncated or adversarial input surfaces as a decode
+// error rather than an oversized allocation, which is the same property
+// a manual bounded reader over each field would buy us, without having
+// to hand-write and maintain one per message. The trade-off is
+// interop: a gob body only talks to another Gyre/Go node, not a
+// non-Go ZRE/Zyre peer speaking the original wire format. MaxFrameSize
+// below still caps the one thing gob itself doesn't bound, the size of
+// the body blob handed to the decoder in the first place.
+package msg
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	zmq "github.com/vaughan0/go-zmq"
+)
+
+// Message ids identify the frame type on the wire.
+const (
+	idHello byte = iota + 1
+	idWhisper
+	idShout
+	idJoin
+	idLeave
+	idPing
+	idPingOk
+	idProtoMsg
+	idGoodbye
+)
+
+// MaxFrameSize bounds the body frame Unmarshal is willing to decode.
+// It exists so a malformed or hostile peer can't make us allocate an
+// unbounded amount of memory for a single message; Unmarshal rejects
+// anything larger before it ever reaches the decoder. Override it
+// before any node starts if 1 MiB is too small or too generous for
+// your messages.
+var MaxFrameSize = 1 << 20 // 1 MiB
+
+// Transit is implemented by every message that can travel between peers.
+type Transit interface {
+	Marshal() ([][]byte, error)
+	Address() []byte
+	SetAddress([]byte)
+	Sequence() uint16
+	SetSequence(uint16)
+	String() string
+}
+
+// envelope carries the routing address a message arrived on. It is never
+// part of the wire encoding, only of the in-memory Transit.
+type envelope struct {
+	address []byte
+}
+
+func (e *envelope) Address() []byte     { return e.address }
+func (e *envelope) SetAddress(a []byte) { e.address = a }
+
+func encode(id byte, body interface{}) ([][]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(body); err != nil {
+		return nil, err
+	}
+	return [][]byte{{id}, buf.Bytes()}, nil
+}
+
+// Unmarshal decodes the frames of one multipart message received on a
+// ROUTER socket (stype) into the matching Transit implementation. The
+// first frame is always the sender's routing address, the second frame
+// is a single message-id byte, the rest is the gob-encoded body.
+//
+// Unmarshal never panics, even on truncated or adversarial input: a
+// malformed body yields an error, and a body over MaxFrameSize is
+// rejected before it's ever handed to the decoder.
+func Unmarshal(stype zmq.SocketType, frames ...[]byte) (t Transit, err error) {
+	if len(frames) < 3 {
+		return nil, fmt.Errorf("msg: expected at least 3 frames, got %d", len(frames))
+	}
+	address, idFrame, body := frames[0], frames[1], frames[2]
+	if len(idFrame) != 1 {
+		return nil, fmt.Errorf("msg: malformed id frame")
+	}
+	if len(body) > MaxFrameSize {
+		return nil, fmt.Errorf("msg: body of %d bytes exceeds MaxFrameSize (%d)", len(body), MaxFrameSize)
+	}
+
+	switch idFrame[0] {
+	case idHello:
+		t = &Hello{}
+	case idWhisper:
+		t = &Whisper{}
+	case idShout:
+		t = &Shout{}
+	case idJoin:
+		t = &Join{}
+	case idLeave:
+		t = &Leave{}
+	case idPing:
+		t = &Ping{}
+	case idPingOk:
+		t = &PingOk{}
+	case idProtoMsg:
+		t = &ProtoMsg{}
+	case idGoodbye:
+		t = &Goodbye{}
+	default:
+		return nil, fmt.Errorf("msg: unknown message id %d", idFrame[0])
+	}
+
+	// gob itself returns errors rather than panicking on malformed
+	// input, but that's an implementation detail we don't want to bet
+	// peer stability on: recover defensively instead.
+	defer func() {
+		if r := recover(); r != nil {
+			t, err = nil, fmt.Errorf("msg: decode: panic: %v", r)
+		}
+	}()
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(t); err != nil {
+		return nil, fmt.Errorf("msg: decode: %v", err)
+	}
+	t.SetAddress(address)
+	return t, nil
+}
+
+// IsHello reports whether frames, as received on the inbox socket before
+// Unmarshal runs, carry a Hello: frames[1] is the message-id frame. Hello
+// is the one message type never sent encrypted (there is no shared key
+// yet when it's sent), so callers deciding whether to decrypt a frame's
+// body check this first.
+func IsHello(frames [][]byte) bool {
+	return len(frames) > 1 && len(frames[1]) == 1 && frames[1][0] == idHello
+}
+
+// Clone returns a copy of m that is safe to mutate (e.g. to set a
+// per-peer sequence number) independently of the original, which is
+// needed when fanning the same command out to several peers.
+func Clone(m Transit) Transit {
+	switch v := m.(type) {
+	case *Hello:
+		c := *v
+		c.Groups = append([]string(nil), v.Groups...)
+		c.Capabilities = append([]Cap(nil), v.Capabilities...)
+		c.Headers = make(map[string]string, len(v.Headers))
+		for k, val := range v.Headers {
+			c.Headers[k] = val
+		}
+		return &c
+	case *Whisper:
+		c := *v
+		return &c
+	case *Shout:
+		c := *v
+		return &c
+	case *Join:
+		c := *v
+		return &c
+	case *Leave:
+		c := *v
+		return &c
+	case *Ping:
+		c := *v
+		return &c
+	case *PingOk:
+		c := *v
+		return &c
+	case *ProtoMsg:
+		c := *v
+		c.Payload = append([]byte(nil), v.Payload...)
+		return &c
+	case *Goodbye:
+		c := *v
+		return &c
+	default:
+		return m
+	}
+}
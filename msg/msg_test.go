@@ -0,0 +1,68 @@
+package msg
+
+import (
+	"strings"
+	"testing"
+
+	zmq "github.com/vaughan0/go-zmq"
+)
+
+// FuzzUnmarshal feeds Unmarshal arbitrary (address, id, body) triples to
+// make sure it never panics and always returns exactly one of a Transit
+// or an error. Bodies are cut to MaxFrameSize, the most Unmarshal decodes.
+func FuzzUnmarshal(f *testing.F) {
+	f.Add([]byte{1, 2, 3}, idHello, []byte{})
+	f.Add([]byte{1, 2, 3}, idWhisper, []byte("not a gob stream"))
+	f.Add([]byte{}, idPing, []byte{})
+	f.Add([]byte{1}, byte(0xff), []byte{0, 0, 0, 0})
+
+	hello, _ := NewHello().Marshal()
+	f.Add([]byte{1, 2, 3}, hello[0][0], hello[1])
+
+	f.Fuzz(func(t *testing.T, address []byte, id byte, body []byte) {
+		if len(body) > MaxFrameSize {
+			body = body[:MaxFrameSize]
+		}
+		transit, err := Unmarshal(zmq.Router, address, []byte{id}, body)
+		if err != nil {
+			if transit != nil {
+				t.Fatalf("Unmarshal returned both a Transit and an error: %v", err)
+			}
+			return
+		}
+		if transit == nil {
+			t.Fatal("Unmarshal returned neither a Transit nor an error")
+		}
+	})
+}
+
+// TestUnmarshalRejectsOversizedBody proves a body over MaxFrameSize is
+// rejected before any allocation proportional to its claimed contents,
+// not merely once gob has already walked it.
+func TestUnmarshalRejectsOversizedBody(t *testing.T) {
+	defer func(old int) { MaxFrameSize = old }(MaxFrameSize)
+	MaxFrameSize = 16
+
+	body := make([]byte, MaxFrameSize+1)
+	_, err := Unmarshal(zmq.Router, []byte{1, 2, 3}, []byte{idWhisper}, body)
+	if err == nil || !strings.Contains(err.Error(), "exceeds MaxFrameSize") {
+		t.Fatalf("expected the MaxFrameSize error for a body over MaxFrameSize, got %v", err)
+	}
+}
+
+// TestUnmarshalShortFrames proves truncated multipart messages are
+// reported as errors instead of panicking on an out-of-range index.
+func TestUnmarshalShortFrames(t *testing.T) {
+	cases := [][][]byte{
+		nil,
+		{},
+		{{1, 2, 3}},
+		{{1, 2, 3}, {idWhisper}},
+		{{1, 2, 3}, {}},
+	}
+	for _, frames := range cases {
+		if _, err := Unmarshal(zmq.Router, frames...); err == nil {
+			t.Errorf("Unmarshal(%v) = nil error, want an error", frames)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package msg
+
+// Ping is sent to a peer that has gone quiet, to force a reply before
+// giving up on it.
+type Ping struct {
+	envelope
+	Seq uint16
+}
+
+// NewPing creates a new, empty Ping message.
+func NewPing() *Ping {
+	return &Ping{}
+}
+
+func (p *Ping) String() string             { return "PING" }
+func (p *Ping) Sequence() uint16           { return p.Seq }
+func (p *Ping) SetSequence(seq uint16)     { p.Seq = seq }
+func (p *Ping) Marshal() ([][]byte, error) { return encode(idPing, p) }
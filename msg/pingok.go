@@ -0,0 +1,17 @@
+package msg
+
+// PingOk replies to a Ping.
+type PingOk struct {
+	envelope
+	Seq uint16
+}
+
+// NewPingOk creates a new, empty PingOk message.
+func NewPingOk() *PingOk {
+	return &PingOk{}
+}
+
+func (p *PingOk) String() string             { return "PING-OK" }
+func (p *PingOk) Sequence() uint16           { return p.Seq }
+func (p *PingOk) SetSequence(seq uint16)     { p.Seq = seq }
+func (p *PingOk) Marshal() ([][]byte, error) { return encode(idPingOk, p) }
@@ -0,0 +1,29 @@
+package msg
+
+// BaseProtocolLength is how many message codes the built-in protocol
+// (HELLO/WHISPER/SHOUT/JOIN/LEAVE/PING/PING-OK, i.e. "gyre/1") reserves.
+// Every other protocol negotiated over a connection is assigned a
+// disjoint range of codes starting above it.
+const BaseProtocolLength = 16
+
+// ProtoMsg carries a single frame for a negotiated subprotocol other
+// than the built-in one. Code is the global wire code: the sender adds
+// its protocol's negotiated offset to a protocol-relative code before
+// marshaling, and the receiver subtracts it back off to route the frame
+// to the right subprotocol handler.
+type ProtoMsg struct {
+	envelope
+	Seq     uint16
+	Code    uint64
+	Payload []byte
+}
+
+// NewProtoMsg creates a new, empty ProtoMsg.
+func NewProtoMsg() *ProtoMsg {
+	return &ProtoMsg{}
+}
+
+func (m *ProtoMsg) String() string             { return "PROTOMSG" }
+func (m *ProtoMsg) Sequence() uint16           { return m.Seq }
+func (m *ProtoMsg) SetSequence(seq uint16)     { m.Seq = seq }
+func (m *ProtoMsg) Marshal() ([][]byte, error) { return encode(idProtoMsg, m) }
@@ -0,0 +1,19 @@
+package msg
+
+// Shout carries a message to every peer in a group.
+type Shout struct {
+	envelope
+	Seq     uint16
+	Group   string
+	Content []byte
+}
+
+// NewShout creates a new, empty Shout message.
+func NewShout() *Shout {
+	return &Shout{}
+}
+
+func (s *Shout) String() string             { return "SHOUT" }
+func (s *Shout) Sequence() uint16           { return s.Seq }
+func (s *Shout) SetSequence(seq uint16)     { s.Seq = seq }
+func (s *Shout) Marshal() ([][]byte, error) { return encode(idShout, s) }
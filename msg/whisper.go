@@ -0,0 +1,18 @@
+package msg
+
+// Whisper carries a direct, one-to-one message to a single peer.
+type Whisper struct {
+	envelope
+	Seq     uint16
+	Content []byte
+}
+
+// NewWhisper creates a new, empty Whisper message.
+func NewWhisper() *Whisper {
+	return &Whisper{}
+}
+
+func (w *Whisper) String() string             { return "WHISPER" }
+func (w *Whisper) Sequence() uint16           { return w.Seq }
+func (w *Whisper) SetSequence(seq uint16)     { w.Seq = seq }
+func (w *Whisper) Marshal() ([][]byte, error) { return encode(idWhisper, w) }
@@ -0,0 +1,68 @@
+package gyre
+
+import (
+	"errors"
+
+	"github.com/armen/gyre/msg"
+)
+
+// Msg is a single frame exchanged within a negotiated subprotocol. Code
+// is relative to the protocol itself (0 is its first message code), not
+// the wire-level code used in ProtoMsg.
+type Msg struct {
+	Code    uint64
+	Payload []byte
+}
+
+// MsgReadWriter is handed to a Protocol's Run function: ReadMsg yields
+// frames addressed to that protocol as they arrive from the peer, and
+// WriteMsg sends one back, translating to and from the connection's
+// global wire codes.
+type MsgReadWriter interface {
+	ReadMsg() (Msg, error)
+	WriteMsg(Msg) error
+}
+
+var errProtoClosed = errors.New("gyre: peer disconnected")
+
+// protoIO implements MsgReadWriter for one negotiated protocol instance
+// on one peer connection. dispatchProtoMsg feeds it from the receive
+// side; WriteMsg hands off to node's command loop, which is the only
+// goroutine allowed to call peer.send.
+type protoIO struct {
+	node   *Node
+	peer   *peer
+	offset uint64
+	length uint64
+	in     chan Msg
+	done   chan struct{}
+}
+
+func (rw *protoIO) ReadMsg() (Msg, error) {
+	select {
+	case m, ok := <-rw.in:
+		if !ok {
+			return Msg{}, errProtoClosed
+		}
+		return m, nil
+	case <-rw.done:
+		return Msg{}, errProtoClosed
+	}
+}
+
+// WriteMsg never calls peer.send itself: handle() is the established
+// single owner of all peer state (sentSequence, the DEALER mailbox,
+// sendAEAD), the same as for Whisper/Shout/Ping, so a subprotocol's
+// frames are routed there as an eventProtoSend command instead of
+// racing handle()'s own goroutine on the same *peer.
+func (rw *protoIO) WriteMsg(m Msg) error {
+	out := msg.NewProtoMsg()
+	out.Code = rw.offset + m.Code
+	out.Payload = m.Payload
+	select {
+	case rw.node.commands <- &Event{Type: eventProtoSend, Peer: rw.peer.identity, transit: out}:
+		return nil
+	case <-rw.done:
+		return errProtoClosed
+	}
+}
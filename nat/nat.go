@@ -0,0 +1,207 @@
+// Package nat provides access to common port mapping protocols, allowing a
+// Gyre node running behind a home router or other NAT to advertise a
+// reachable external address to its peers.
+package nat
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interface is implemented by the various port mapping mechanisms.  A
+// node holding one asks it for the external address and uses it to open
+// (and keep open) a mapping for its inbox port.
+type Interface interface {
+	// AddMapping maps the given internal port to extport on the
+	// gateway's external interface. protocol is "TCP" or "UDP". The
+	// mapping is expected to expire after lifetime and must be
+	// refreshed by the caller before then.
+	AddMapping(protocol string, extport, intport int, name string, lifetime time.Duration) error
+
+	// DeleteMapping removes a previously added mapping.
+	DeleteMapping(protocol string, extport, intport int) error
+
+	// ExternalIP returns the gateway's Internet-facing address.
+	ExternalIP() (net.IP, error)
+
+	// String returns a description of the mechanism, used for logging.
+	String() string
+}
+
+// Parse parses a NAT mechanism description as it would appear on a command
+// line or in a config file:
+//
+//	""                   no NAT traversal
+//	"any"                tries every mechanism in turn, uses the first that answers
+//	"upnp"               Universal Plug and Play (IGD1/IGD2)
+//	"pmp"                NAT-PMP against the default gateway
+//	"pmp:192.168.1.1"    NAT-PMP against an explicit gateway
+//	"extip:203.0.113.7"  static mapping, for deployments with a known public IP
+func Parse(spec string) (Interface, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	mech := strings.ToLower(parts[0])
+
+	switch mech {
+	case "", "none", "off":
+		return nil, nil
+	case "any", "auto":
+		return Any(), nil
+	case "upnp":
+		return UPnP(), nil
+	case "pmp", "natpmp", "nat-pmp":
+		if len(parts) == 1 {
+			return PMP(nil), nil
+		}
+		gw := net.ParseIP(parts[1])
+		if gw == nil {
+			return nil, fmt.Errorf("nat: invalid gateway address %q", parts[1])
+		}
+		return PMP(gw), nil
+	case "extip", "ext-ip":
+		if len(parts) == 1 {
+			return nil, errors.New("nat: extip requires an address, e.g. extip:1.2.3.4")
+		}
+		ip := net.ParseIP(parts[1])
+		if ip == nil {
+			return nil, fmt.Errorf("nat: invalid IP address %q", parts[1])
+		}
+		return ExtIP(ip), nil
+	default:
+		return nil, fmt.Errorf("nat: unknown mechanism %q", parts[0])
+	}
+}
+
+// ExtIP implements Interface for a statically known external IP. It never
+// touches the network: AddMapping and DeleteMapping are no-ops, since the
+// operator is assumed to have arranged port forwarding out of band.
+type ExtIP net.IP
+
+func (ip ExtIP) ExternalIP() (net.IP, error) { return net.IP(ip), nil }
+func (ip ExtIP) String() string              { return fmt.Sprintf("extip(%v)", net.IP(ip)) }
+
+func (ExtIP) AddMapping(string, int, int, string, time.Duration) error { return nil }
+func (ExtIP) DeleteMapping(string, int, int) error                     { return nil }
+
+// UPnP returns an Interface that discovers an IGD1/IGD2 router over SSDP
+// the first time it is used.
+func UPnP() Interface {
+	return &autodisc{what: "UPnP", discover: discoverUPnP}
+}
+
+// PMP returns an Interface that speaks NAT-PMP to gw. When gw is nil, the
+// default gateway is discovered from the local routing table the first
+// time the interface is used.
+func PMP(gw net.IP) Interface {
+	if gw != nil {
+		return &pmp{gw: gw}
+	}
+	return &autodisc{what: "NAT-PMP", discover: discoverPMP}
+}
+
+// Any returns an Interface that tries UPnP and NAT-PMP in order and keeps
+// whichever answers first.
+func Any() Interface {
+	return &autodisc{
+		what: "NAT",
+		discover: func() Interface {
+			if found := discoverUPnP(); found != nil {
+				return found
+			}
+			return discoverPMP()
+		},
+	}
+}
+
+// autodisc lazily runs discover() on first use and caches the result (which
+// may be nil, meaning no gateway answered) for the life of the process.
+type autodisc struct {
+	what     string
+	discover func() Interface
+
+	once  sync.Once
+	found Interface
+}
+
+func (n *autodisc) resolve() (Interface, error) {
+	n.once.Do(func() { n.found = n.discover() })
+	if n.found == nil {
+		return nil, fmt.Errorf("nat: no %s gateway found", n.what)
+	}
+	return n.found, nil
+}
+
+func (n *autodisc) AddMapping(protocol string, extport, intport int, name string, lifetime time.Duration) error {
+	found, err := n.resolve()
+	if err != nil {
+		return err
+	}
+	return found.AddMapping(protocol, extport, intport, name, lifetime)
+}
+
+func (n *autodisc) DeleteMapping(protocol string, extport, intport int) error {
+	found, err := n.resolve()
+	if err != nil {
+		return err
+	}
+	return found.DeleteMapping(protocol, extport, intport)
+}
+
+func (n *autodisc) ExternalIP() (net.IP, error) {
+	found, err := n.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return found.ExternalIP()
+}
+
+func (n *autodisc) String() string {
+	if n.found != nil {
+		return n.found.String()
+	}
+	return n.what
+}
+
+const (
+	// MapTimeout is the lifetime requested for a port mapping. The
+	// mapping is refreshed well before this elapses.
+	MapTimeout = 20 * time.Minute
+
+	mapRefreshInterval = 15 * time.Minute
+)
+
+// Map adds a port mapping on m and keeps it alive until done is closed, at
+// which point the mapping is deleted. It is meant to run in its own
+// goroutine. Every time the mapping is refreshed (or on the very first
+// successful mapping), the external address reported by m is sent on
+// extip so the caller can react to it changing.
+func Map(m Interface, done <-chan struct{}, protocol string, extport, intport int, name string, extip chan<- net.IP) {
+	refresh := time.NewTicker(mapRefreshInterval)
+	defer refresh.Stop()
+	defer m.DeleteMapping(protocol, extport, intport)
+
+	renew := func() {
+		if err := m.AddMapping(protocol, extport, intport, name, MapTimeout); err != nil {
+			return
+		}
+		if ip, err := m.ExternalIP(); err == nil && extip != nil {
+			select {
+			case extip <- ip:
+			default:
+			}
+		}
+	}
+
+	renew()
+	for {
+		select {
+		case <-done:
+			return
+		case <-refresh.C:
+			renew()
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package nat
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NAT-PMP (RFC 6886) opcodes.
+const (
+	pmpVersion        = 0
+	opExternalAddress = 0
+	opMapUDP          = 1
+	opMapTCP          = 2
+	pmpPort           = 5351
+)
+
+// pmp implements Interface by speaking NAT-PMP directly to gw.
+type pmp struct {
+	gw net.IP
+}
+
+func (n *pmp) String() string {
+	return fmt.Sprintf("NAT-PMP(%v)", n.gw)
+}
+
+func (n *pmp) ExternalIP() (net.IP, error) {
+	resp, err := n.request([]byte{pmpVersion, opExternalAddress}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func (n *pmp) AddMapping(protocol string, extport, intport int, name string, lifetime time.Duration) error {
+	op := byte(opMapUDP)
+	if protocol == "TCP" {
+		op = opMapTCP
+	}
+	req := make([]byte, 12)
+	req[1] = op
+	putUint16(req[4:6], uint16(intport))
+	putUint16(req[6:8], uint16(extport))
+	putUint32(req[8:12], uint32(lifetime/time.Second))
+	_, err := n.request(req, 16)
+	return err
+}
+
+// DeleteMapping removes a mapping by requesting it again with a zero
+// lifetime, as specified in RFC 6886 section 3.4.
+func (n *pmp) DeleteMapping(protocol string, extport, intport int) error {
+	return n.AddMapping(protocol, 0, intport, "", 0)
+}
+
+// request sends req to the gateway's NAT-PMP port, retrying with the
+// protocol's recommended backoff, and returns the resultSize-byte
+// response.
+func (n *pmp) request(req []byte, resultSize int) ([]byte, error) {
+	conn, err := net.Dial("udp4", net.JoinHostPort(n.gw.String(), strconv.Itoa(pmpPort)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result := make([]byte, resultSize)
+	timeout := 250 * time.Millisecond
+	for attempt := 0; attempt < 4; attempt++ {
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err = conn.Write(req); err != nil {
+			return nil, err
+		}
+		nread, rerr := conn.Read(result)
+		if rerr == nil && nread == resultSize {
+			if result[3] != 0 {
+				return nil, fmt.Errorf("nat-pmp: gateway returned error code %d", result[3])
+			}
+			return result, nil
+		}
+		err = rerr
+		timeout *= 2
+	}
+	if err == nil {
+		err = errors.New("nat-pmp: short response from gateway")
+	}
+	return nil, err
+}
+
+func putUint16(b []byte, v uint16) { b[0] = byte(v >> 8); b[1] = byte(v) }
+func putUint32(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
+
+// discoverPMP looks up the default gateway and checks that it answers
+// NAT-PMP requests, returning nil if not.
+func discoverPMP() Interface {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil
+	}
+	p := &pmp{gw: gw}
+	if _, err := p.ExternalIP(); err != nil {
+		return nil
+	}
+	return p
+}
+
+// defaultGateway reads the kernel routing table to find the default
+// route's gateway address. It only supports Linux, which is what Gyre
+// nodes are primarily deployed on; other platforms fail discovery
+// gracefully and fall back to UPnP or an explicit nat.PMP(gw).
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header line
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue // not the default route
+		}
+		hex := fields[2]
+		if len(hex) != 8 {
+			continue
+		}
+		var b [4]byte
+		for i := 0; i < 4; i++ {
+			v, err := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
+			if err != nil {
+				return nil, err
+			}
+			b[3-i] = byte(v)
+		}
+		return net.IPv4(b[0], b[1], b[2], b[3]), nil
+	}
+	return nil, errors.New("nat: no default gateway found")
+}
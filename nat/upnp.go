@@ -0,0 +1,258 @@
+package nat
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr = "239.255.255.250:1900"
+	ssdpMx   = 2 * time.Second
+)
+
+// upnpService is the subset of an IGD's service list we care about: the
+// WAN(IP|PPP)Connection service that exposes AddPortMapping.
+type upnpService struct {
+	serviceType string
+	controlURL  string
+}
+
+// upnp implements Interface against an IGD1/IGD2 Internet Gateway Device
+// discovered over SSDP.
+type upnp struct {
+	device  string
+	service upnpService
+}
+
+func (n *upnp) String() string {
+	return fmt.Sprintf("UPnP(%s)", n.device)
+}
+
+func (n *upnp) ExternalIP() (net.IP, error) {
+	resp, err := n.soapCall("GetExternalIPAddress", "")
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(extractTag(resp, "NewExternalIPAddress"))
+	if ip == nil {
+		return nil, fmt.Errorf("upnp: malformed GetExternalIPAddress response")
+	}
+	return ip, nil
+}
+
+func (n *upnp) AddMapping(protocol string, extport, intport int, name string, lifetime time.Duration) error {
+	args := fmt.Sprintf(`
+<NewRemoteHost></NewRemoteHost>
+<NewExternalPort>%d</NewExternalPort>
+<NewProtocol>%s</NewProtocol>
+<NewInternalPort>%d</NewInternalPort>
+<NewInternalClient>%s</NewInternalClient>
+<NewEnabled>1</NewEnabled>
+<NewPortMappingDescription>%s</NewPortMappingDescription>
+<NewLeaseDuration>%d</NewLeaseDuration>`,
+		extport, protocol, intport, localAddrFor(n), name, int(lifetime/time.Second))
+	_, err := n.soapCall("AddPortMapping", args)
+	return err
+}
+
+func (n *upnp) DeleteMapping(protocol string, extport, intport int) error {
+	args := fmt.Sprintf(`
+<NewRemoteHost></NewRemoteHost>
+<NewExternalPort>%d</NewExternalPort>
+<NewProtocol>%s</NewProtocol>`, extport, protocol)
+	_, err := n.soapCall("DeletePortMapping", args)
+	return err
+}
+
+// soapCall issues a SOAP request for action against the device's control
+// URL and returns the raw response body.
+func (n *upnp) soapCall(action, args string) ([]byte, error) {
+	body := fmt.Sprintf(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:%s xmlns:u="%s">%s</u:%s></s:Body></s:Envelope>`,
+		action, n.service.serviceType, args, action)
+
+	req, err := http.NewRequest("POST", n.service.controlURL, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, n.service.serviceType, action))
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upnp: %s failed: %s", action, buf.String())
+	}
+	return buf.Bytes(), nil
+}
+
+// localAddrFor returns the address of the local interface used to reach
+// the IGD, so AddPortMapping maps to the right NewInternalClient.
+func localAddrFor(n *upnp) string {
+	ctrl, err := url.Parse(n.service.controlURL)
+	if err != nil {
+		return ""
+	}
+	// Dialing UDP sends nothing; it only picks the outgoing interface.
+	conn, err := net.Dial("udp4", net.JoinHostPort(ctrl.Hostname(), "1900"))
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func extractTag(xmlBody []byte, tag string) string {
+	open, close := "<"+tag+">", "</"+tag+">"
+	s := string(xmlBody)
+	i := strings.Index(s, open)
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len(open):]
+	j := strings.Index(s, close)
+	if j < 0 {
+		return ""
+	}
+	return s[:j]
+}
+
+// discoverUPnP sends an SSDP M-SEARCH multicast, fetches the first
+// responding device's description, and picks out its WANIPConnection (or
+// WANPPPConnection, for older IGD1 devices) service. It returns nil if no
+// device answers or none exposes a usable connection service.
+func discoverUPnP() Interface {
+	loc, err := ssdpSearch()
+	if err != nil {
+		return nil
+	}
+	svc, err := fetchConnectionService(loc)
+	if err != nil {
+		return nil
+	}
+	return &upnp{device: loc, service: svc}
+}
+
+func ssdpSearch() (location string, err error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	raddr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+
+	if _, err := conn.WriteToUDP([]byte(req), raddr); err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(ssdpMx))
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\r\n") {
+			if loc := matchHeader(line, "location"); loc != "" {
+				return loc, nil
+			}
+		}
+	}
+}
+
+func matchHeader(line, header string) string {
+	idx := strings.IndexByte(line, ':')
+	if idx < 0 || !strings.EqualFold(strings.TrimSpace(line[:idx]), header) {
+		return ""
+	}
+	return strings.TrimSpace(line[idx+1:])
+}
+
+// igdDesc is the minimal subset of a UPnP device description document
+// needed to locate a connection service's control URL.
+type igdDesc struct {
+	Device struct {
+		DeviceList struct {
+			Device []struct {
+				DeviceList struct {
+					Device []struct {
+						ServiceList struct {
+							Service []igdServiceDesc `xml:"service"`
+						} `xml:"serviceList"`
+					} `xml:"device"`
+				} `xml:"deviceList"`
+				ServiceList struct {
+					Service []igdServiceDesc `xml:"service"`
+				} `xml:"serviceList"`
+			} `xml:"device"`
+		} `xml:"deviceList"`
+	} `xml:"device"`
+}
+
+type igdServiceDesc struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+func fetchConnectionService(location string) (upnpService, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(location)
+	if err != nil {
+		return upnpService{}, err
+	}
+	defer resp.Body.Close()
+
+	var desc igdDesc
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return upnpService{}, err
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return upnpService{}, err
+	}
+
+	var services []igdServiceDesc
+	for _, d := range desc.Device.DeviceList.Device {
+		services = append(services, d.ServiceList.Service...)
+		for _, d2 := range d.DeviceList.Device {
+			services = append(services, d2.ServiceList.Service...)
+		}
+	}
+	for _, svc := range services {
+		if strings.Contains(svc.ServiceType, "WANIPConnection") ||
+			strings.Contains(svc.ServiceType, "WANPPPConnection") {
+			ctrl, err := base.Parse(svc.ControlURL)
+			if err != nil {
+				continue
+			}
+			return upnpService{serviceType: svc.ServiceType, controlURL: ctrl.String()}, nil
+		}
+	}
+	return upnpService{}, fmt.Errorf("upnp: no WANIPConnection/WANPPPConnection service found")
+}
@@ -8,15 +8,20 @@ package gyre
 import (
 	"github.com/armen/gyre/beacon"
 	"github.com/armen/gyre/msg"
+	"github.com/armen/gyre/nat"
 	zmq "github.com/vaughan0/go-zmq"
 
 	"bytes"
+	"crypto/ed25519"
 	crand "crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,7 +31,12 @@ const (
 	// IANA-assigned port for ZRE discovery protocol
 	zreDiscoveryPort = 5670
 
-	beaconVersion = 0x1
+	// beaconVersionV1 is the original, key-less beacon payload.
+	// beaconVersion is sent once a node has a NodeKey and appends its
+	// Ed25519 public key after the v1 fields; recvFromBeacon still
+	// parses v1 beacons for backwards compatibility with older peers.
+	beaconVersionV1 = 0x1
+	beaconVersion   = 0x2
 
 	// Port range 0xc000~0xffff is defined by IANA for dynamic or private ports
 	// We use this when choosing a port for dynamic binding
@@ -42,21 +52,47 @@ const (
 	EventJoin    = "JOIN"
 	EventLeave   = "LEAVE"
 	EventSet     = "SET"
+	EventReject  = "REJECT" // a peer failed authentication or authorization
+
+	// eventProtoSend is an internal-only command type, never seen on
+	// Chan(): it routes a negotiated subprotocol's WriteMsg through
+	// handle()'s command loop so peer.send is only ever called from
+	// handle()'s own goroutine, the same rule Whisper/Shout/Ping follow.
+	eventProtoSend = "protoSend"
+
+	// eventRegisterProtocol is an internal-only command type, never seen
+	// on Chan(): it routes Node.RegisterProtocol's write to n.protocols
+	// through handle()'s command loop, since that map is also read by
+	// negotiateProtocols/capabilities from handle()'s own goroutine.
+	eventRegisterProtocol = "registerProtocol"
 )
 
 type sig struct {
-	Protocol [3]byte
-	Version  byte
-	Uuid     []byte
-	Port     uint16
+	Protocol  [3]byte
+	Version   byte
+	Uuid      []byte
+	Port      uint16
+	PublicKey []byte // only present when Version == beaconVersion
 }
 
 type Event struct {
-	Type    string
-	Peer    string
-	Group   string
-	Key     string // Only used for EventSet
-	Content []byte
+	Type       string
+	Peer       string
+	Group      string
+	Key        string // Only used for EventSet
+	Content    []byte
+	Reason     byte   // Only used for EventExit; a msg.Reason* constant, from the peer's Goodbye or our own verdict (msg.ReasonTimeout if it went quiet)
+	ReasonText string // Only used for EventExit; the Goodbye's free-text explanation, if any
+
+	// transit is only used by the internal eventProtoSend command: it
+	// carries the already-built message a protoIO.WriteMsg wants handed
+	// to peer.send from handle()'s goroutine.
+	transit msg.Transit
+
+	// protocol is only used by the internal eventRegisterProtocol
+	// command: it carries the Protocol RegisterProtocol wants added to
+	// n.protocols from handle()'s goroutine.
+	protocol *Protocol
 }
 
 type Node struct {
@@ -76,10 +112,38 @@ type Node struct {
 	PeerGroups map[string]*group // Groups that our peers are in
 	OwnGroups  map[string]*group // Groups that we are in
 	Headers    map[string]string // Our header values
+
+	nat      nat.Interface // Port mapper, nil unless Config.NAT is set
+	natDone  chan struct{} // Closed to stop the NAT refresh/watch goroutine
+	natExtIP chan net.IP   // External IPs handed from manageNat to handle(), which owns Host
+
+	key        *NodeKey                 // Our long-lived identity, nil unless Config.NodeKey is set
+	authorizer func(pubkey []byte) bool // Optional peer key whitelist, from Config.PeerAuthorizer
+
+	protocols map[string]Protocol // Registered subprotocols, by name; see RegisterProtocol
+
+	store     PeerStore              // Persists known peers, nil unless Config.Store is set
+	records   map[string]*PeerRecord // Known peers, by identity; mirrors store on disk
+	bootstrap []string               // Seed peers to dial on start-up, from Config.Bootstrap
+
+	MaxPeers int // Peer cap, 0 for unlimited; from Config.MaxPeers
 }
 
-// NewNode creates a new node.
+// NewNode creates a new node with the default configuration: a random
+// dynamic port and LAN-only discovery via the beacon.
 func NewNode() (node *Node, err error) {
+	return NewNodeWithConfig(Config{})
+}
+
+// NewNodeWithConfig creates a new node using the given Config, further
+// customized by any opts (see WithNodeKey, WithPeerAuthorizer). Use this
+// instead of NewNode to enable NAT traversal or other non-default
+// behaviour.
+func NewNodeWithConfig(config Config, opts ...Option) (node *Node, err error) {
+	for _, opt := range opts {
+		opt(&config)
+	}
+
 	node = &Node{
 		quit:       make(chan struct{}),
 		events:     make(chan *Event),
@@ -88,6 +152,27 @@ func NewNode() (node *Node, err error) {
 		PeerGroups: make(map[string]*group),
 		OwnGroups:  make(map[string]*group),
 		Headers:    make(map[string]string),
+		nat:        config.NAT,
+		key:        config.NodeKey,
+		authorizer: config.PeerAuthorizer,
+		protocols:  make(map[string]Protocol),
+		store:      config.Store,
+		records:    make(map[string]*PeerRecord),
+		bootstrap:  config.Bootstrap,
+		MaxPeers:   config.MaxPeers,
+	}
+	for _, proto := range config.Protocols {
+		node.protocols[proto.Name] = proto
+	}
+	if node.store != nil {
+		if recs, err := node.store.Load(); err != nil {
+			log.Printf("W: peer store load failed: %v", err)
+		} else {
+			for _, rec := range recs {
+				r := rec
+				node.records[r.Uuid] = &r
+			}
+		}
 	}
 	node.wg.Add(1) // We're going to wait until handler() is done
 
@@ -106,25 +191,16 @@ func NewNode() (node *Node, err error) {
 		}
 	}
 
-	// Generate random uuid
-	node.Uuid = make([]byte, 16)
-	io.ReadFull(crand.Reader, node.Uuid)
+	if node.key != nil {
+		// A configured NodeKey gives the node a stable identity
+		// across restarts instead of a random one.
+		node.Uuid = node.key.uuid()
+	} else {
+		node.Uuid = make([]byte, 16)
+		io.ReadFull(crand.Reader, node.Uuid)
+	}
 	node.Identity = fmt.Sprintf("%X", node.Uuid)
 
-	s := &sig{}
-	s.Protocol[0] = 'Z'
-	s.Protocol[1] = 'R'
-	s.Protocol[2] = 'E'
-	s.Version = beaconVersion
-	s.Uuid = node.Uuid
-	s.Port = node.Port
-
-	buffer := new(bytes.Buffer)
-	binary.Write(buffer, binary.BigEndian, s.Protocol)
-	binary.Write(buffer, binary.BigEndian, s.Version)
-	binary.Write(buffer, binary.BigEndian, s.Uuid)
-	binary.Write(buffer, binary.BigEndian, s.Port)
-
 	// Create a beacon
 	node.Beacon, err = beacon.New(zreDiscoveryPort)
 	if err != nil {
@@ -133,13 +209,53 @@ func NewNode() (node *Node, err error) {
 	node.Host = node.Beacon.Addr()
 	node.Beacon.NoEcho()
 	node.Beacon.Subscribe([]byte("ZRE"))
-	node.Beacon.Publish(buffer.Bytes())
+	node.Beacon.Publish(node.buildBeacon())
+
+	if node.nat != nil {
+		node.natDone = make(chan struct{})
+		node.natExtIP = make(chan net.IP, 1)
+		go node.manageNat()
+	}
 
 	go node.handle()
 
 	return
 }
 
+// buildBeacon serialises our Uuid and Port (and, if we have a NodeKey,
+// our public key) into a ZRE beacon payload. Host is not part of it:
+// receivers take the address from the beacon's UDP source.
+func (n *Node) buildBeacon() []byte {
+	s := &sig{}
+	s.Protocol[0] = 'Z'
+	s.Protocol[1] = 'R'
+	s.Protocol[2] = 'E'
+	s.Version = beaconVersionV1
+	if n.key != nil {
+		s.Version = beaconVersion
+	}
+	s.Uuid = n.Uuid
+	s.Port = n.Port
+
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, s.Protocol)
+	binary.Write(buffer, binary.BigEndian, s.Version)
+	binary.Write(buffer, binary.BigEndian, s.Uuid)
+	binary.Write(buffer, binary.BigEndian, s.Port)
+	if n.key != nil {
+		binary.Write(buffer, binary.BigEndian, []byte(n.key.Public))
+	}
+	return buffer.Bytes()
+}
+
+// manageNat holds a port mapping open on the configured nat.Interface for
+// as long as the node is running, reporting external address changes on
+// natExtIP. It never touches Host itself: that's handle()'s job, so that
+// every read and write of it stays on handle()'s single goroutine.
+func (n *Node) manageNat() {
+	nat.Map(n.nat, n.natDone, "TCP", int(n.Port), int(n.Port), "gyre", n.natExtIP)
+}
+
 // Sends message to single peer. peer ID is first frame in message.
 func (n *Node) Whisper(identity string, content []byte) *Node {
 	n.commands <- &Event{
@@ -204,6 +320,18 @@ func (n *Node) whisper(identity string, content []byte) {
 	}
 }
 
+// sendProtoMsg is handle()'s side of protoIO.WriteMsg: it's the only
+// place a negotiated subprotocol's frames reach peer.send, so they can
+// never race the built-in Whisper/Shout/Ping traffic handle() sends
+// directly.
+func (n *Node) sendProtoMsg(identity string, transit msg.Transit) {
+	peer, ok := n.Peers[identity]
+	if !ok || !peer.ready {
+		return
+	}
+	peer.send(transit)
+}
+
 func (n *Node) shout(group string, content []byte) {
 	// Get group to send message to
 	if g, ok := n.PeerGroups[group]; ok {
@@ -265,6 +393,8 @@ func (n *Node) handle() {
 		n.wg.Done()
 	}()
 
+	n.seedPeers()
+
 	chans := n.inbox.Channels()
 	defer chans.Close()
 
@@ -289,11 +419,27 @@ func (n *Node) handle() {
 				n.leave(e.Group)
 			case EventSet:
 				n.set(e.Key, e.Content)
+			case eventProtoSend:
+				n.sendProtoMsg(e.Peer, e.transit)
+			case eventRegisterProtocol:
+				n.protocols[e.protocol.Name] = *e.protocol
 			}
 
 		case frames := <-chans.In():
+			frames, err := n.decryptFrames(frames)
+			if err != nil {
+				log.Printf("W: [%s] dropping undecryptable frame: %v", n.Identity, err)
+				continue
+			}
 			transit, err := msg.Unmarshal(stype, frames...)
 			if err != nil {
+				log.Printf("W: [%s] dropping malformed frame: %v", n.Identity, err)
+				if len(frames) > 0 && len(frames[0]) > 1 {
+					if peer, ok := n.Peers[string(frames[0][1:])]; ok && peer.ready {
+						peer.send(msg.NewGoodbye(msg.ReasonProtocolError, "malformed frame"))
+						n.exitPeer(peer, msg.ReasonProtocolError, "malformed frame")
+					}
+				}
 				continue
 			}
 			n.recvFromPeer(transit)
@@ -304,15 +450,57 @@ func (n *Node) handle() {
 		case err := <-chans.Errors():
 			log.Println(err)
 
+		case ip := <-n.natExtIP:
+			if ip.String() == n.Host {
+				continue
+			}
+			n.Host = ip.String()
+			n.Beacon.Publish(n.buildBeacon())
+			log.Printf("I: [%s] external address changed to %s:%d, re-beaconing", n.Identity, n.Host, n.Port)
+
 		case <-ping:
 			ping = time.After(reapInterval)
+			// Snapshot first: pingPeer can reinsert a sticky peer's
+			// identity into n.Peers via retrySticky, and mutating a map
+			// while ranging over it is undefined by the Go spec (the
+			// new entry may or may not be produced this iteration).
+			due := make([]*peer, 0, len(n.Peers))
 			for _, peer := range n.Peers {
+				due = append(due, peer)
+			}
+			for _, peer := range due {
 				n.pingPeer(peer)
 			}
 		}
 	}
 }
 
+// decryptFrames opens the body frame of an incoming message if it was
+// sent by a peer we've completed the crypto handshake with. HELLO frames
+// are never encrypted (there is no shared key yet) even from a peer
+// we've already established a session with, e.g. one that restarted and
+// reconnected under the same NodeKey-derived identity while we still
+// have its old recvAEAD cached, so they're passed through untouched
+// symmetric with the skip peer.send already does on the way out; peers
+// we have no key for are passed through untouched too.
+func (n *Node) decryptFrames(frames [][]byte) ([][]byte, error) {
+	if len(frames) < 3 || msg.IsHello(frames) {
+		return frames, nil
+	}
+	identity := string(frames[0][1:])
+	peer, ok := n.Peers[identity]
+	if !ok || peer.recvAEAD == nil {
+		return frames, nil
+	}
+
+	plain, err := peer.open(frames[2])
+	if err != nil {
+		return nil, err
+	}
+	out := append([][]byte{}, frames[:2]...)
+	return append(out, plain), nil
+}
+
 // recvFromPeer handles messages coming from other peers
 func (n *Node) recvFromPeer(transit msg.Transit) {
 	// Router socket tells us the identity of this peer
@@ -326,6 +514,18 @@ func (n *Node) recvFromPeer(transit msg.Transit) {
 		// On HELLO we may create the peer if it's unknown
 		// On other commands the peer must already exist
 		peer = n.requirePeer(identity, m.Ipaddress, m.Mailbox)
+		if peer == nil {
+			// Already told, and shown the door, by requirePeer.
+			return
+		}
+
+		if !n.authenticate(peer, m) {
+			log.Printf("W: [%s] rejecting peer %s, failed authentication", n.Identity, identity)
+			n.events <- &Event{Type: EventReject, Peer: identity}
+			peer.send(msg.NewGoodbye(msg.ReasonUnauthorized, ""))
+			peer.disconnect()
+			return
+		}
 		peer.ready = true
 	}
 
@@ -336,7 +536,9 @@ func (n *Node) recvFromPeer(transit msg.Transit) {
 	}
 
 	if !peer.checkMessage(transit) {
-		log.Printf("W: [%s] lost messages from %s", n.Identity, identity)
+		log.Printf("W: [%s] lost messages from %s, disconnecting", n.Identity, identity)
+		peer.send(msg.NewGoodbye(msg.ReasonProtocolError, "out of sequence"))
+		n.exitPeer(peer, msg.ReasonProtocolError, "out of sequence")
 		return
 	}
 
@@ -356,6 +558,12 @@ func (n *Node) recvFromPeer(transit msg.Transit) {
 		// Hello command holds latest status of peer
 		peer.status = m.Status
 
+		n.recordPeer(identity, peer.endpoint, peer.headers, peer.sticky)
+
+		// Work out which of our registered Protocols this peer also
+		// supports, and hand each one its own goroutine.
+		n.negotiateProtocols(peer, m.Capabilities)
+
 	case *msg.Whisper:
 		// Pass up to caller API as WHISPER event
 		n.events <- &Event{
@@ -388,12 +596,140 @@ func (n *Node) recvFromPeer(transit msg.Transit) {
 		if m.Status != peer.status {
 			log.Printf("W: [%s] message status isn't equal to peer status, %d != %d", n.Identity, m.Status, peer.status)
 		}
+
+	case *msg.ProtoMsg:
+		n.dispatchProtoMsg(peer, m)
+
+	case *msg.Goodbye:
+		// The peer is hanging up on purpose; take it at its word
+		// instead of waiting for it to go quiet.
+		n.exitPeer(peer, m.Reason, m.Text)
+		return
 	}
 
 	// Activity from peer resets peer timers
 	peer.refresh()
 }
 
+// exitPeer reports a peer as gone, tells every group it was in, and
+// tears down its connection. reason/reasonText are either a msg.Reason*
+// constant taken from a Goodbye the peer sent us, or our own verdict
+// (e.g. msg.ReasonTimeout) when we're the one giving up on it.
+func (n *Node) exitPeer(peer *peer, reason byte, reasonText string) {
+	n.events <- &Event{
+		Type:       EventExit,
+		Peer:       peer.identity,
+		Reason:     reason,
+		ReasonText: reasonText,
+	}
+	for _, group := range n.PeerGroups {
+		group.leave(peer)
+	}
+	peer.disconnect()
+	delete(n.Peers, peer.identity)
+}
+
+// authenticate completes the crypto handshake for a Hello carrying a
+// signed ephemeral key, verifying the signature and (if a
+// PeerAuthorizer was configured) that the static key is allowed to
+// connect. It always returns true when we have no NodeKey configured,
+// since there is then nothing to authenticate against; once we do have
+// one, a Hello with no Ephemeral is a bare rejection, not a free pass.
+func (n *Node) authenticate(peer *peer, m *msg.Hello) bool {
+	if n.key == nil {
+		return true
+	}
+	if len(m.Ephemeral) == 0 {
+		return false
+	}
+	if len(m.StaticKey) != ed25519.PublicKeySize || !ed25519.Verify(m.StaticKey, handshakeTranscript(m.Ephemeral, n.Identity), m.Signature) {
+		return false
+	}
+	if n.authorizer != nil && !n.authorizer(m.StaticKey) {
+		return false
+	}
+	if peer.ephemeralPriv == nil {
+		// requirePeer always generates our side of the exchange when
+		// the peer is first created and n.key is set, so this would
+		// only be nil for a peer we somehow never sent a HELLO to.
+		return false
+	}
+	peer.staticKey = m.StaticKey
+	return peer.completeHandshake(m.Ephemeral, n.Identity, peer.identity) == nil
+}
+
+// negotiateProtocols works out which of our registered Protocols peer
+// also offered in its HELLO, assigns each a disjoint range of message
+// codes above msg.BaseProtocolLength (in name order, so both sides agree
+// on the same offsets), and spawns its Run goroutine. It only runs once
+// per peer: a HELLO is only ever resent as the very first message.
+func (n *Node) negotiateProtocols(peer *peer, remote []Cap) {
+	if peer.protosNegotiated {
+		return
+	}
+	peer.protosNegotiated = true
+
+	names := make([]string, 0, len(n.protocols))
+	for name := range n.protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	offset := uint64(msg.BaseProtocolLength)
+	for _, name := range names {
+		proto := n.protocols[name]
+		shared := false
+		for _, c := range remote {
+			if c.Name == proto.Name && c.Version == proto.Version {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			continue
+		}
+
+		rw := &protoIO{
+			node:   n,
+			peer:   peer,
+			offset: offset,
+			length: proto.Length,
+			in:     make(chan Msg, 16),
+			done:   make(chan struct{}),
+		}
+		peer.protos = append(peer.protos, rw)
+		offset += proto.Length
+
+		pc := &PeerConn{Identity: peer.identity}
+		if host, port, err := splitEndpoint(peer.endpoint); err == nil {
+			pc.Host = host
+			pc.Port = port
+		}
+		go func(proto Protocol, rw *protoIO) {
+			if err := proto.Run(pc, rw); err != nil {
+				log.Printf("W: [%s] protocol %s/%d with peer %s exited: %v", n.Identity, proto.Name, proto.Version, peer.identity, err)
+			}
+		}(proto, rw)
+	}
+}
+
+// dispatchProtoMsg routes an incoming ProtoMsg to whichever negotiated
+// protocol owns its code range.
+func (n *Node) dispatchProtoMsg(peer *peer, m *msg.ProtoMsg) {
+	for _, rw := range peer.protos {
+		if m.Code < rw.offset || m.Code >= rw.offset+rw.length {
+			continue
+		}
+		select {
+		case rw.in <- Msg{Code: m.Code - rw.offset, Payload: m.Payload}:
+		default:
+			log.Printf("W: [%s] dropping frame, subprotocol queue full for peer %s", n.Identity, peer.identity)
+		}
+		return
+	}
+	log.Printf("W: [%s] dropping frame for unnegotiated protocol code %d from %s", n.Identity, m.Code, peer.identity)
+}
+
 // recvFromBeacon handles a new signal received from beacon
 func (n *Node) recvFromBeacon(b *beacon.Signal) {
 	// Get IP address and beacon of peer
@@ -412,23 +748,214 @@ func (n *Node) recvFromBeacon(b *beacon.Signal) {
 
 	binary.Read(buffer, binary.BigEndian, &s.Port)
 
-	// Ignore anything that isn't a valid beacon
-	if s.Version == beaconVersion {
-		// Check that the peer, identified by its UUID, exists
-		identity := fmt.Sprintf("%X", s.Uuid)
-		peer := n.requirePeer(identity, ipaddress, s.Port)
-		peer.refresh()
+	switch s.Version {
+	case beaconVersionV1:
+		// No public key in the v1 beacon payload.
+	case beaconVersion:
+		s.PublicKey = make([]byte, ed25519.PublicKeySize)
+		binary.Read(buffer, binary.BigEndian, s.PublicKey)
+	default:
+		// Unrecognised beacon version, ignore.
+		return
+	}
+
+	// Check that the peer, identified by its UUID, exists
+	identity := fmt.Sprintf("%X", s.Uuid)
+	peer := n.requirePeer(identity, ipaddress, s.Port)
+	if peer == nil {
+		return
+	}
+	if len(s.PublicKey) > 0 {
+		peer.staticKey = s.PublicKey
+	}
+	peer.refresh()
+}
+
+// seedPeers dials every address in n.bootstrap and every peer n.store
+// remembers from a previous run, skipping the beacon entirely: since we
+// already know the remote identity, requirePeer synthesizes the HELLO
+// handshake directly against the stored endpoint. It must run from
+// inside handle(), before the main select loop, so it shares n.Peers'
+// single-goroutine ownership with everything else that touches it.
+func (n *Node) seedPeers() {
+	seen := make(map[string]bool)
+	for _, spec := range n.bootstrap {
+		identity, host, port, err := parseBootstrapSpec(spec)
+		if err != nil {
+			log.Printf("W: [%s] skipping bootstrap entry: %v", n.Identity, err)
+			continue
+		}
+		seen[identity] = true
+		peer := n.requirePeer(identity, host, port)
+		if peer == nil {
+			continue
+		}
+		peer.sticky = true
+	}
+
+	for identity, rec := range n.records {
+		if seen[identity] {
+			continue
+		}
+		host, port, err := splitEndpoint(rec.Endpoint)
+		if err != nil {
+			log.Printf("W: [%s] skipping stored peer %s: %v", n.Identity, identity, err)
+			continue
+		}
+		peer := n.requirePeer(identity, host, port)
+		if peer == nil {
+			continue
+		}
+		peer.sticky = rec.Sticky
 	}
 }
 
+// parseBootstrapSpec parses a Config.Bootstrap entry of the form
+// "identity@host:port".
+func parseBootstrapSpec(spec string) (identity, host string, port uint16, err error) {
+	parts := strings.SplitN(spec, "@", 2)
+	if len(parts) != 2 {
+		return "", "", 0, fmt.Errorf("bootstrap entry %q must be \"identity@host:port\"", spec)
+	}
+	host, port, err = splitEndpoint(parts[1])
+	return parts[0], host, port, err
+}
+
+func splitEndpoint(endpoint string) (host string, port uint16, err error) {
+	h, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q: %v", endpoint, err)
+	}
+	return h, uint16(p), nil
+}
+
+// recordPeer updates our in-memory and on-disk record of a peer we've
+// just heard from. A no-op when no Config.Store was configured.
+func (n *Node) recordPeer(identity, endpoint string, headers map[string]string, sticky bool) {
+	if n.store == nil {
+		return
+	}
+	rec, ok := n.records[identity]
+	if !ok {
+		rec = &PeerRecord{Uuid: identity}
+		n.records[identity] = rec
+	}
+	rec.Endpoint = endpoint
+	rec.LastSeen = time.Now()
+	if len(headers) > 0 {
+		rec.Headers = headers
+	}
+	rec.Sticky = rec.Sticky || sticky
+	n.saveStore()
+}
+
+// peerRecordTTL is how long a non-sticky record is kept after its peer
+// was last seen, before store rotation drops it.
+const peerRecordTTL = 7 * 24 * time.Hour
+
+// saveStore rewrites the peer store, dropping non-sticky records that
+// have aged out so the file doesn't grow forever with peers that will
+// never come back.
+func (n *Node) saveStore() {
+	if n.store == nil {
+		return
+	}
+	cutoff := time.Now().Add(-peerRecordTTL)
+	records := make([]PeerRecord, 0, len(n.records))
+	for identity, rec := range n.records {
+		if !rec.Sticky && rec.LastSeen.Before(cutoff) {
+			delete(n.records, identity)
+			continue
+		}
+		records = append(records, *rec)
+	}
+	if err := n.store.Save(records); err != nil {
+		log.Printf("W: [%s] failed to save peer store: %v", n.Identity, err)
+	}
+}
+
+// PeerRecords returns a snapshot of every peer this node has recorded,
+// including ones it isn't currently connected to.
+func (n *Node) PeerRecords() []PeerRecord {
+	records := make([]PeerRecord, 0, len(n.records))
+	for _, rec := range n.records {
+		records = append(records, *rec)
+	}
+	return records
+}
+
+// stickyMaxBackoffShift caps the exponent used by backoffDelay so the
+// retry interval itself never runs away to something absurd.
+const stickyMaxBackoffShift = 6 // 1s, 2s, 4s, ... capped at 64s
+
+// backoffDelay is the interval a sticky peer waits before its next
+// reconnect attempt, doubling with every failure up to a cap.
+func backoffDelay(retries int) time.Duration {
+	shift := minInt(retries, stickyMaxBackoffShift)
+	return time.Duration(1<<uint(shift)) * time.Second
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// retrySticky is called once a second for a disconnected sticky peer.
+// Once its backoff has elapsed it redials the same endpoint under a
+// fresh peer record, so that bootstrap/seed peers survive a transient
+// outage instead of being forgotten like an ordinary one.
+func (n *Node) retrySticky(peer *peer) {
+	if time.Now().Before(peer.nextRetry) {
+		return
+	}
+
+	host, port, err := splitEndpoint(peer.endpoint)
+	if err != nil {
+		log.Printf("W: [%s] giving up on sticky peer %s: %v", n.Identity, peer.identity, err)
+		delete(n.Peers, peer.identity)
+		return
+	}
+
+	identity := peer.identity
+	retries := peer.retries + 1
+	delete(n.Peers, identity)
+
+	fresh := n.requirePeer(identity, host, port)
+	if fresh == nil {
+		return
+	}
+	fresh.sticky = true
+	fresh.retries = retries
+	fresh.nextRetry = time.Now().Add(backoffDelay(retries))
+}
+
 // requirePeer finds or creates peer via its UUID string
 func (n *Node) requirePeer(identity, address string, port uint16) (peer *peer) {
 	peer, ok := n.Peers[identity]
 	if !ok {
-		// Purge any previous peer on same endpoint
+		if n.MaxPeers > 0 && len(n.Peers) >= n.MaxPeers {
+			log.Printf("W: [%s] rejecting peer %s, at MaxPeers (%d)", n.Identity, identity, n.MaxPeers)
+			reject := newPeer(identity)
+			reject.connect(n.Identity, fmt.Sprintf("%s:%d", address, port))
+			reject.send(msg.NewGoodbye(msg.ReasonTooManyPeers, ""))
+			reject.disconnect()
+			return nil
+		}
+
+		// Purge any previous peer on same endpoint: its identity must
+		// have changed underneath it, since a still-live peer at the
+		// same endpoint would already be keyed under the identity we
+		// were asked for.
 		endpoint := fmt.Sprintf("%s:%d", address, port)
 		for _, p := range n.Peers {
 			if p.endpoint == endpoint {
+				p.send(msg.NewGoodbye(msg.ReasonDuplicateIdentity, ""))
 				p.disconnect()
 			}
 		}
@@ -447,8 +974,18 @@ func (n *Node) requirePeer(identity, address string, port uint16) (peer *peer) {
 		for key, header := range n.Headers {
 			m.Headers[key] = header
 		}
+		m.Capabilities = n.capabilities()
+		if n.key != nil {
+			if priv, pub, err := generateEphemeral(); err == nil {
+				peer.ephemeralPriv = priv
+				m.Ephemeral = pub
+				m.StaticKey = []byte(n.key.Public)
+				m.Signature = ed25519.Sign(n.key.Private, handshakeTranscript(pub, identity))
+			}
+		}
 		peer.send(m)
 		n.Peers[identity] = peer
+		n.recordPeer(identity, endpoint, nil, peer.sticky)
 
 		// Now tell the caller about the peer
 		n.events <- &Event{
@@ -501,20 +1038,29 @@ func (n *Node) leavePeerGroup(peer *peer, name string) {
 // - if peer has gone quiet, send TCP ping
 // - if peer has disappeared, expire it
 func (n *Node) pingPeer(peer *peer) {
+	if !peer.connected && peer.sticky {
+		// A disconnected sticky peer is waiting out its backoff; it
+		// stays in n.Peers but is neither pinged nor reaped, only
+		// redialed by retrySticky once the backoff has elapsed.
+		n.retrySticky(peer)
+		return
+	}
+
 	if time.Now().Unix() >= peer.expiredAt.Unix() {
-		// If peer has really vanished, expire it
-		n.events <- &Event{
-			Type: EventExit,
-			Peer: peer.identity,
-		}
-		for _, group := range n.PeerGroups {
-			group.leave(peer)
+		// If peer has really vanished, expire it. It's really important
+		// to leave its groups and disconnect before deleting it from
+		// n.Peers, unless we'd end up with difficulties reconnecting to
+		// the same endpoint.
+		sticky := peer.sticky
+		n.exitPeer(peer, msg.ReasonTimeout, "")
+		if sticky {
+			// Bootstrap/seed peers aren't simply forgotten: keep
+			// retrying the same endpoint with exponential backoff
+			// instead of leaving them out of n.Peers for good.
+			peer.sticky = true
+			n.Peers[peer.identity] = peer
+			n.retrySticky(peer)
 		}
-		// It's really important to disconnect from the peer before
-		// deleting it, unless we'd end up difficulties to reconnect
-		// to the same endpoint
-		peer.disconnect()
-		delete(n.Peers, peer.identity)
 	} else if time.Now().Unix() >= peer.evasiveAt.Unix() {
 		//  If peer is being evasive, force a TCP ping.
 		//  TODO: do this only once for a peer in this state;
@@ -527,6 +1073,9 @@ func (n *Node) pingPeer(peer *peer) {
 
 // Disconnect leaves all the groups and the closes all the connections to the peers
 func (n *Node) Disconnect() {
+	if n.natDone != nil {
+		close(n.natDone)
+	}
 	close(n.quit)
 	n.wg.Wait()
 
@@ -536,11 +1085,10 @@ func (n *Node) Disconnect() {
 		// and Leave sends communicate to events channel which obviously blocks
 		n.leave(group)
 	}
-	// Disconnect from all peers
+	// Disconnect from all peers, telling each one why first so it
+	// doesn't have to wait out its own reaper to find out
 	for peerId, peer := range n.Peers {
-		// It's really important to disconnect from the peer before
-		// deleting it, unless we'd end up difficulties to reconnect
-		// to the same endpoint
+		peer.send(msg.NewGoodbye(msg.ReasonShutdown, ""))
 		peer.disconnect()
 		delete(n.Peers, peerId)
 	}
@@ -0,0 +1,185 @@
+package gyre
+
+import (
+	"crypto/cipher"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/armen/gyre/msg"
+	zmq "github.com/vaughan0/go-zmq"
+)
+
+const (
+	// reapInterval is how often Node.handle checks peers for silence.
+	reapInterval = 1 * time.Second
+
+	// peerEvasiveTimeout is how long a peer may stay silent before we
+	// force a TCP ping to make sure it's still there.
+	peerEvasiveTimeout = 5 * time.Second
+
+	// peerExpiredTimeout is how long a peer may stay silent, including
+	// not answering a ping, before we give up on it.
+	peerExpiredTimeout = 10 * time.Second
+)
+
+// peer is our view of one other node: its inbox connection, its last
+// known headers/status, and (when node keys are in use) the keys needed
+// to authenticate and encrypt traffic to and from it.
+type peer struct {
+	identity string
+	endpoint string
+	mailbox  *zmq.Socket
+
+	connected bool
+	ready     bool
+	status    byte
+	headers   map[string]string
+
+	sentSequence     uint16
+	expectedSequence uint16
+
+	evasiveAt time.Time
+	expiredAt time.Time
+
+	// sticky, retries and nextRetry support bootstrap/seed peers:
+	// instead of being dropped at the first expiry, they're redialed
+	// with exponential backoff. See Node.retrySticky.
+	sticky    bool
+	retries   int
+	nextRetry time.Time
+
+	// Crypto handshake state, populated only when the owning Node has a
+	// NodeKey configured. ephemeralPriv is generated when we first hear
+	// of this peer; sendAEAD/recvAEAD are derived once the peer's own
+	// Hello brings us its ephemeral public key.
+	ephemeralPriv []byte
+	staticKey     []byte
+	sendAEAD      cipher.AEAD
+	recvAEAD      cipher.AEAD
+
+	// protosNegotiated and protos track the outcome of capability
+	// negotiation from this peer's first HELLO: which of our
+	// registered Protocols it also supports, and the per-protocol
+	// MsgReadWriter handed to each one's Run goroutine.
+	protosNegotiated bool
+	protos           []*protoIO
+}
+
+func newPeer(identity string) *peer {
+	return &peer{
+		identity: identity,
+		headers:  make(map[string]string),
+	}
+}
+
+// connect opens the DEALER connection to this peer's inbox, identifying
+// ourselves with replyTo (our own identity) so the peer's ROUTER socket
+// can address replies back to us.
+func (p *peer) connect(replyTo, endpoint string) error {
+	socket, err := zmq.NewSocket(zmq.Dealer)
+	if err != nil {
+		return err
+	}
+
+	uuid, err := hex.DecodeString(replyTo)
+	if err != nil {
+		socket.Close()
+		return fmt.Errorf("peer: malformed identity %q: %v", replyTo, err)
+	}
+	// SetIdentitiy is go-zmq's own spelling, and it has nothing to
+	// report: zmq_setsockopt failures here would mean we passed a
+	// malformed identity, which can't happen since uuid just came back
+	// from a successful hex.DecodeString.
+	socket.SetIdentitiy(append([]byte{1}, uuid...))
+	if err := socket.Connect(fmt.Sprintf("tcp://%s", endpoint)); err != nil {
+		socket.Close()
+		return err
+	}
+
+	p.mailbox = socket
+	p.endpoint = endpoint
+	p.connected = true
+	p.refresh()
+	return nil
+}
+
+// send marshals m, stamps it with the next outgoing sequence number,
+// encrypts its body if a send key has been established, and puts it on
+// the wire.
+func (p *peer) send(m msg.Transit) error {
+	if p.mailbox == nil {
+		return errors.New("peer: not connected")
+	}
+
+	p.sentSequence++
+	m.SetSequence(p.sentSequence)
+
+	frames, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	if p.sendAEAD != nil {
+		if _, isHello := m.(*msg.Hello); !isHello {
+			frames[1] = p.seal(frames[1])
+		}
+	}
+	return p.mailbox.Send(frames)
+}
+
+func (p *peer) disconnect() {
+	if p.mailbox != nil {
+		p.mailbox.Close()
+		p.mailbox = nil
+	}
+	p.connected = false
+	p.ready = false
+
+	for _, rw := range p.protos {
+		close(rw.done)
+	}
+	p.protos = nil
+}
+
+// refresh resets the evasive/expired deadlines after activity from this
+// peer (or after we successfully establish the connection to it).
+func (p *peer) refresh() {
+	now := time.Now()
+	p.evasiveAt = now.Add(peerEvasiveTimeout)
+	p.expiredAt = now.Add(peerExpiredTimeout)
+}
+
+// checkMessage verifies transit arrived in order. On a mismatch it still
+// adopts the sequence number it saw as the new expected one; recvFromPeer
+// treats any mismatch as a protocol error and drops the peer.
+func (p *peer) checkMessage(transit msg.Transit) bool {
+	p.expectedSequence++
+	ok := transit.Sequence() == p.expectedSequence
+	if !ok {
+		p.expectedSequence = transit.Sequence()
+	}
+	return ok
+}
+
+// seal encrypts plaintext for sending, prefixing a fresh random nonce.
+func (p *peer) seal(plaintext []byte) []byte {
+	nonce := make([]byte, p.sendAEAD.NonceSize())
+	io.ReadFull(crand.Reader, nonce)
+	return append(nonce, p.sendAEAD.Seal(nil, nonce, plaintext, nil)...)
+}
+
+// open decrypts a payload previously produced by the peer's seal.
+func (p *peer) open(ciphertext []byte) ([]byte, error) {
+	if p.recvAEAD == nil {
+		return ciphertext, nil
+	}
+	size := p.recvAEAD.NonceSize()
+	if len(ciphertext) < size {
+		return nil, errors.New("peer: ciphertext shorter than nonce")
+	}
+	nonce, box := ciphertext[:size], ciphertext[size:]
+	return p.recvAEAD.Open(nil, nonce, box, nil)
+}
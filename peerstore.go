@@ -0,0 +1,65 @@
+package gyre
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// PeerRecord is everything a PeerStore remembers about one peer across
+// restarts.
+type PeerRecord struct {
+	Uuid     string
+	Endpoint string
+	LastSeen time.Time
+	Headers  map[string]string
+
+	// Sticky marks a bootstrap/seed peer: pingPeer retries it with
+	// exponential backoff instead of dropping it after one expiry.
+	Sticky bool
+}
+
+// PeerStore persists what a node has learned about its peers so a
+// restarted node can reconnect directly instead of waiting to rediscover
+// them over the beacon, and so a set of well-known seeds can bootstrap a
+// cluster that spans more than one LAN segment.
+type PeerStore interface {
+	Load() ([]PeerRecord, error)
+	Save(records []PeerRecord) error
+}
+
+// fileStore is the default, file-backed PeerStore: it serialises every
+// record as JSON to a single file.
+type fileStore struct {
+	path string
+}
+
+// NewFileStore returns a PeerStore that keeps its records in a single
+// JSON file at path, creating it on first Save.
+func NewFileStore(path string) PeerStore {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) Load() ([]PeerRecord, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []PeerRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *fileStore) Save(records []PeerRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
@@ -0,0 +1,64 @@
+package gyre
+
+import "github.com/armen/gyre/msg"
+
+// Cap identifies a subprotocol by name and version, as exchanged in the
+// HELLO handshake.
+type Cap = msg.Cap
+
+// Protocol is a subprotocol a Node can run alongside the built-in
+// Whisper/Shout/Join/Leave/Ping messages, which themselves run as the
+// implicit "gyre" protocol, version 1, at message-code offset 0.
+// Registering a Protocol lets an application ship its own request/
+// response, file transfer, or streaming logic over the same ROUTER/
+// DEALER connection without forking Gyre, and cleanly supports a
+// rolling upgrade: peers that don't share a protocol simply don't
+// negotiate it.
+type Protocol struct {
+	Name    string
+	Version uint
+
+	// Length is how many message codes this protocol reserves. Once
+	// negotiated with a peer, it is assigned a disjoint range of codes
+	// starting right after msg.BaseProtocolLength (and after any
+	// other protocol negotiated ahead of it, in name order).
+	Length uint64
+
+	// Run is spawned in its own goroutine for every peer the protocol
+	// was negotiated with. It should loop on rw.ReadMsg() until the
+	// connection goes away, at which point ReadMsg returns an error.
+	Run func(p *PeerConn, rw MsgReadWriter) error
+}
+
+func (p Protocol) cap() Cap { return Cap{Name: p.Name, Version: p.Version} }
+
+// PeerConn exposes read-only information about a connected peer to a
+// running Protocol.
+type PeerConn struct {
+	Identity string
+	Host     string
+	Port     uint16
+}
+
+// RegisterProtocol adds proto to the set a node offers its peers. Call
+// it before the node's HELLO handshakes start (i.e. right after
+// NewNode/NewNodeWithConfig returns): protocols are only negotiated
+// while processing a peer's HELLO, so one registered afterwards will be
+// missed by peers that connected earlier. The actual map write happens
+// on handle()'s goroutine, which also reads n.protocols from
+// negotiateProtocols/capabilities, so this call returns before the
+// registration is necessarily visible to an in-flight negotiation.
+func (n *Node) RegisterProtocol(proto Protocol) {
+	n.commands <- &Event{Type: eventRegisterProtocol, protocol: &proto}
+}
+
+// capabilities lists every protocol this node offers, for inclusion in
+// our own HELLO.
+func (n *Node) capabilities() []Cap {
+	caps := make([]Cap, 0, len(n.protocols)+1)
+	caps = append(caps, Cap{Name: "gyre", Version: 1})
+	for _, proto := range n.protocols {
+		caps = append(caps, proto.cap())
+	}
+	return caps
+}